@@ -0,0 +1,277 @@
+// Copyright © by Jeff Foley 2017-2022. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package resolve
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var errCacheQueryFailed = errors.New("query failed")
+
+// DefaultMaxNegativeTTL bounds how long a negative (NXDOMAIN/NoData) answer is cached
+// when the authority section's SOA MINIMUM would otherwise request a longer one.
+const DefaultMaxNegativeTTL = 1 * time.Hour
+
+// DefaultPrefetchThreshold re-resolves a cached name once this fraction of its TTL remains.
+const DefaultPrefetchThreshold = 0.1
+
+// CachedResolvers sits in front of a Resolvers pool and serves answers out of an
+// in-memory, TTL-aware LRU cache before falling back to the pool on a miss.
+type CachedResolvers struct {
+	res            *Resolvers
+	cache          *cache
+	MaxNegativeTTL time.Duration
+	Prefetch       bool
+}
+
+// NewCachedResolvers wraps r with a response cache holding up to size entries.
+func NewCachedResolvers(r *Resolvers, size int) *CachedResolvers {
+	c := &CachedResolvers{
+		res:            r,
+		cache:          newCache(size),
+		MaxNegativeTTL: DefaultMaxNegativeTTL,
+	}
+
+	go c.cache.sweep(r.done)
+	return c
+}
+
+// Query consults the cache before queuing the message with the wrapped resolver pool.
+func (c *CachedResolvers) Query(ctx context.Context, msg *dns.Msg, ch chan *dns.Msg) {
+	if msg == nil || len(msg.Question) == 0 {
+		c.res.Query(ctx, msg, ch)
+		return
+	}
+
+	key := cacheKey(msg.Question[0])
+	if entry, found := c.cache.get(key); found {
+		resp := entry.msg.Copy()
+		resp.Id = msg.Id
+		applyRemainingTTL(resp, entry.remaining())
+		ch <- resp
+
+		if c.Prefetch && entry.remaining() < entry.ttl.Seconds()*DefaultPrefetchThreshold {
+			go c.refresh(msg)
+		}
+		return
+	}
+
+	out := make(chan *dns.Msg, 1)
+	c.res.Query(ctx, msg, out)
+	go c.await(key, out, ch)
+}
+
+// QueryChan queues the provided DNS message and sends the response on the returned channel.
+func (c *CachedResolvers) QueryChan(ctx context.Context, msg *dns.Msg) chan *dns.Msg {
+	ch := make(chan *dns.Msg, 1)
+	c.Query(ctx, msg, ch)
+	return ch
+}
+
+// QueryBlocking queues the provided DNS message and returns the associated response message.
+func (c *CachedResolvers) QueryBlocking(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	ch := c.QueryChan(ctx, msg)
+
+	select {
+	case <-ctx.Done():
+		return msg, ctx.Err()
+	case resp := <-ch:
+		if resp == nil {
+			return resp, errCacheQueryFailed
+		}
+		return resp, nil
+	}
+}
+
+func (c *CachedResolvers) await(key string, out, ch chan *dns.Msg) {
+	resp := <-out
+	c.store(key, resp)
+	ch <- resp
+}
+
+func (c *CachedResolvers) refresh(msg *dns.Msg) {
+	out := c.res.QueryChan(context.Background(), msg.Copy())
+	resp := <-out
+	c.store(cacheKey(msg.Question[0]), resp)
+}
+
+func (c *CachedResolvers) store(key string, resp *dns.Msg) {
+	if resp == nil {
+		return
+	}
+
+	if resp.Rcode == dns.RcodeNameError || (resp.Rcode == dns.RcodeSuccess && len(resp.Answer) == 0) {
+		if ttl, ok := negativeTTL(resp); ok {
+			if max := c.MaxNegativeTTL; max > 0 && ttl > max {
+				ttl = max
+			}
+			c.cache.put(key, resp, ttl)
+		}
+		return
+	}
+
+	if resp.Rcode != dns.RcodeSuccess {
+		return
+	}
+
+	ttl := minTTL(resp)
+	if ttl <= 0 {
+		return
+	}
+	c.cache.put(key, resp, ttl)
+}
+
+// negativeTTL implements RFC 2308 negative caching: the TTL of a cached negative
+// response is bounded by the MINIMUM field of the SOA record in the authority section.
+func negativeTTL(m *dns.Msg) (time.Duration, bool) {
+	for _, rr := range m.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl := soa.Minttl
+			if hdr := soa.Header().Ttl; hdr < ttl {
+				ttl = hdr
+			}
+			return time.Duration(ttl) * time.Second, true
+		}
+	}
+	return 0, false
+}
+
+// applyRemainingTTL rewrites every RR header TTL in m to reflect remaining seconds
+// of life left in the cache entry (ttl_stored - (now - insertedAt)), so a cache hit
+// never hands out a TTL longer than what's actually left.
+func applyRemainingTTL(m *dns.Msg, remaining float64) {
+	ttl := uint32(0)
+	if remaining > 0 {
+		ttl = uint32(remaining)
+	}
+
+	for _, set := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range set {
+			rr.Header().Ttl = ttl
+		}
+	}
+}
+
+func minTTL(m *dns.Msg) time.Duration {
+	var min uint32
+	have := false
+
+	for _, set := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range set {
+			ttl := rr.Header().Ttl
+			if !have || ttl < min {
+				min = ttl
+				have = true
+			}
+		}
+	}
+	if !have {
+		return 0
+	}
+	return time.Duration(min) * time.Second
+}
+
+func cacheKey(q dns.Question) string {
+	return strings.ToLower(RemoveLastDot(q.Name)) + "|" + dns.TypeToString[q.Qtype] + "|" + dns.ClassToString[q.Qclass]
+}
+
+type cacheEntry struct {
+	key        string
+	msg        *dns.Msg
+	ttl        time.Duration
+	insertedAt time.Time
+	elem       *list.Element
+}
+
+func (e *cacheEntry) remaining() float64 {
+	return (e.ttl - time.Since(e.insertedAt)).Seconds()
+}
+
+func (e *cacheEntry) expired() bool {
+	return time.Since(e.insertedAt) >= e.ttl
+}
+
+// cache is a TTL-aware LRU keyed by qname|qtype|qclass, swept periodically in the
+// background in the same manner as the sweep goroutine in resolver.timeouts.
+type cache struct {
+	sync.Mutex
+	size    int
+	entries map[string]*cacheEntry
+	order   *list.List
+}
+
+func newCache(size int) *cache {
+	return &cache{
+		size:    size,
+		entries: make(map[string]*cacheEntry),
+		order:   list.New(),
+	}
+}
+
+func (c *cache) get(key string) (*cacheEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	entry, found := c.entries[key]
+	if !found {
+		return nil, false
+	}
+	if entry.expired() {
+		c.removeLocked(entry)
+		return nil, false
+	}
+
+	c.order.MoveToFront(entry.elem)
+	return entry, true
+}
+
+func (c *cache) put(key string, msg *dns.Msg, ttl time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	if entry, found := c.entries[key]; found {
+		c.removeLocked(entry)
+	}
+
+	entry := &cacheEntry{key: key, msg: msg.Copy(), ttl: ttl, insertedAt: time.Now()}
+	entry.elem = c.order.PushFront(entry)
+	c.entries[key] = entry
+
+	for c.size > 0 && len(c.entries) > c.size {
+		c.removeLocked(c.order.Back().Value.(*cacheEntry))
+	}
+}
+
+func (c *cache) removeLocked(entry *cacheEntry) {
+	delete(c.entries, entry.key)
+	c.order.Remove(entry.elem)
+}
+
+func (c *cache) sweep(done chan struct{}) {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			c.Lock()
+			for _, entry := range c.entries {
+				if entry.expired() {
+					c.removeLocked(entry)
+				}
+			}
+			c.Unlock()
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright © by Jeff Foley 2017-2022. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package resolve
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestCacheGetExpired(t *testing.T) {
+	c := newCache(10)
+	msg := new(dns.Msg)
+	msg.SetQuestion("www.example.com.", dns.TypeA)
+
+	c.put("www.example.com|A|IN", msg, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	if _, found := c.get("www.example.com|A|IN"); found {
+		t.Fatal("expired cache entry was returned as a hit")
+	}
+}
+
+func TestCacheGetHit(t *testing.T) {
+	c := newCache(10)
+	msg := new(dns.Msg)
+	msg.SetQuestion("www.example.com.", dns.TypeA)
+
+	c.put("www.example.com|A|IN", msg, time.Minute)
+
+	entry, found := c.get("www.example.com|A|IN")
+	if !found {
+		t.Fatal("expected a cache hit")
+	}
+	if entry.msg.Question[0].Name != "www.example.com." {
+		t.Fatalf("unexpected cached message: %+v", entry.msg)
+	}
+}
+
+func TestNegativeTTLUsesSOAMinttl(t *testing.T) {
+	m := new(dns.Msg)
+	m.Rcode = dns.RcodeNameError
+	m.Ns = append(m.Ns, &dns.SOA{
+		Hdr:    dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Minttl: 300,
+	})
+
+	ttl, ok := negativeTTL(m)
+	if !ok {
+		t.Fatal("expected a negative TTL to be derived from the SOA record")
+	}
+	if ttl != 300*time.Second {
+		t.Fatalf("expected the SOA MINIMUM (300s) to bound the negative TTL, got %s", ttl)
+	}
+}
+
+func TestApplyRemainingTTLDecrementsHeaders(t *testing.T) {
+	m := new(dns.Msg)
+	m.Answer = append(m.Answer, &dns.A{Hdr: dns.RR_Header{Ttl: 600}})
+	m.Ns = append(m.Ns, &dns.NS{Hdr: dns.RR_Header{Ttl: 600}})
+
+	applyRemainingTTL(m, 42.7)
+
+	for _, rr := range append(m.Answer, m.Ns...) {
+		if rr.Header().Ttl != 42 {
+			t.Fatalf("expected every RR TTL to be rewritten to the remaining 42s, got %d", rr.Header().Ttl)
+		}
+	}
+}
+
+func TestApplyRemainingTTLFloorsAtZero(t *testing.T) {
+	m := new(dns.Msg)
+	m.Answer = append(m.Answer, &dns.A{Hdr: dns.RR_Header{Ttl: 600}})
+
+	applyRemainingTTL(m, -5)
+
+	if ttl := m.Answer[0].Header().Ttl; ttl != 0 {
+		t.Fatalf("expected a negative remaining TTL to floor at 0, got %d", ttl)
+	}
+}
+
+func TestMinTTL(t *testing.T) {
+	m := new(dns.Msg)
+	m.Answer = append(m.Answer, &dns.A{Hdr: dns.RR_Header{Ttl: 300}})
+	m.Extra = append(m.Extra, &dns.A{Hdr: dns.RR_Header{Ttl: 60}})
+
+	if got := minTTL(m); got != 60*time.Second {
+		t.Fatalf("expected minTTL to return the smallest RR TTL (60s), got %s", got)
+	}
+}
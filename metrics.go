@@ -0,0 +1,140 @@
+// Copyright © by Jeff Foley 2017-2022. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package resolve
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ResolverMetrics is a point-in-time snapshot of one upstream resolver's activity.
+type ResolverMetrics struct {
+	Address    string
+	QueueDepth int
+	RTTMillis  int64
+}
+
+// Metrics is a point-in-time snapshot of a resolver pool's activity, suitable for
+// exporting to a monitoring system such as Prometheus.
+type Metrics struct {
+	QueriesSent      int64
+	ResponsesByRcode map[string]int64
+	Timeouts         int64
+	TCPFallbacks     int64
+	WildcardDetected int64
+	QueueDepth       int
+	Resolvers        []ResolverMetrics
+}
+
+// poolMetrics holds the running counters backing Resolvers.Metrics. The exchange
+// counters are updated from logExchange, which already observes one call per
+// completed or failed exchange, so no extra instrumentation is threaded through the
+// query path; wildcardDetected is updated only through RecordWildcardDetected.
+//
+// These counters are kept apart from the per-resolver resolver.stats that
+// collectStats maintains: Metrics does not read resolver.stats.
+type poolMetrics struct {
+	queriesSent      int64
+	timeouts         int64
+	tcpFallbacks     int64
+	wildcardDetected int64
+	rcodes           sync.Map
+}
+
+func newPoolMetrics() *poolMetrics {
+	return new(poolMetrics)
+}
+
+func (m *poolMetrics) observe(entry QueryLogEntry) {
+	atomic.AddInt64(&m.queriesSent, 1)
+
+	if entry.RetriedTCP {
+		atomic.AddInt64(&m.tcpFallbacks, 1)
+	}
+	if entry.Error == errExchangeTimeout.Error() {
+		atomic.AddInt64(&m.timeouts, 1)
+	}
+
+	if entry.Rcode != "" {
+		v, _ := m.rcodes.LoadOrStore(entry.Rcode, new(int64))
+		atomic.AddInt64(v.(*int64), 1)
+	}
+}
+
+func (m *poolMetrics) recordWildcardDetected() {
+	atomic.AddInt64(&m.wildcardDetected, 1)
+}
+
+func (m *poolMetrics) snapshot() Metrics {
+	snap := Metrics{
+		QueriesSent:      atomic.LoadInt64(&m.queriesSent),
+		Timeouts:         atomic.LoadInt64(&m.timeouts),
+		TCPFallbacks:     atomic.LoadInt64(&m.tcpFallbacks),
+		WildcardDetected: atomic.LoadInt64(&m.wildcardDetected),
+		ResponsesByRcode: make(map[string]int64),
+	}
+
+	m.rcodes.Range(func(k, v interface{}) bool {
+		snap.ResponsesByRcode[k.(string)] = atomic.LoadInt64(v.(*int64))
+		return true
+	})
+	return snap
+}
+
+// RTTObserverFunc receives one round-trip-time sample per completed exchange with
+// the named resolver, suitable for feeding a histogram instead of only an average.
+type RTTObserverFunc func(address string, rtt time.Duration)
+
+// SetRTTObserver installs fn to be called once per completed exchange with the
+// resolver's address and observed round-trip time. Unlike Metrics().Resolvers[].RTTMillis,
+// which only exposes the smoothed EWMA, this gives callers (e.g. a Prometheus
+// HistogramVec) every raw sample so percentile/tail latency stays visible.
+func (r *Resolvers) SetRTTObserver(fn RTTObserverFunc) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.rttObserver = fn
+}
+
+func (r *Resolvers) observeRTT(address string, d time.Duration) {
+	r.Lock()
+	fn := r.rttObserver
+	r.Unlock()
+
+	if fn != nil {
+		fn(address, d)
+	}
+}
+
+// Metrics returns a point-in-time snapshot of this pool's query activity, including
+// in-flight queue depth and per-resolver queue depth and RTT.
+func (r *Resolvers) Metrics() Metrics {
+	snap := r.metrics.snapshot()
+	snap.QueueDepth = r.queue.Len()
+
+	all := r.pool.AllResolvers()
+	snap.Resolvers = make([]ResolverMetrics, 0, len(all))
+	for _, res := range all {
+		snap.Resolvers = append(snap.Resolvers, ResolverMetrics{
+			Address:    res.address,
+			QueueDepth: res.xchgQueue.Len(),
+			RTTMillis:  res.rtt.value().Milliseconds(),
+		})
+	}
+	return snap
+}
+
+// RecordWildcardDetected increments the pool's wildcard-detection counter so the
+// count is reflected in Metrics and any exported Prometheus counter. This pool has
+// no wildcard-domain detection algorithm of its own to hook automatically: the
+// wildcards/detector fields on Resolvers predate this metrics work, and populating
+// them is a separate, unimplemented feature, not something this change can wire
+// into. Until that detector exists, Metrics().WildcardDetected stays at 0 unless a
+// caller doing its own wildcard filtering on top of this pool calls
+// RecordWildcardDetected for every name it suppresses.
+func (r *Resolvers) RecordWildcardDetected() {
+	r.metrics.recordWildcardDetected()
+}
@@ -0,0 +1,194 @@
+// Copyright © by Jeff Foley 2017-2022. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package resolve
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// QueryStrategy selects how Resolvers picks which upstream(s) handle each query.
+type QueryStrategy int
+
+const (
+	// StrategyRandom sends each query to a single resolver chosen by the pool's Selector.
+	StrategyRandom QueryStrategy = iota
+	// StrategyParallelBest races a query against several resolvers at once and keeps
+	// the first valid answer, discarding the rest.
+	StrategyParallelBest
+)
+
+// DefaultParallelBestN is the number of resolvers raced per query in StrategyParallelBest
+// when ParallelBest has not been called to override it.
+const DefaultParallelBestN = 2
+
+// epsilonExplore is the fraction of parallel-best picks that include a random resolver
+// in place of one of the fastest, so a resolver that has recovered can be rediscovered.
+const epsilonExplore = 0.1
+
+// SetQueryStrategy selects how queries are dispatched to the pool's resolvers.
+func (r *Resolvers) SetQueryStrategy(s QueryStrategy) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.strategy = s
+}
+
+// ParallelBest enables StrategyParallelBest and sets the number of resolvers raced
+// for each query. n is clamped to at least 2.
+func (r *Resolvers) ParallelBest(n int) {
+	if n < 2 {
+		n = 2
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.strategy = StrategyParallelBest
+	r.parallelN = n
+}
+
+// dispatchParallelBest sends req to several resolvers at once and delivers the
+// first non-error, non-SERVFAIL answer to the caller, discarding the stragglers.
+func (r *Resolvers) dispatchParallelBest(req *request) {
+	n := r.parallelN
+	if n < 2 {
+		n = DefaultParallelBestN
+	}
+
+	targets := r.pickParallelTargets(n, req.Name)
+	if len(targets) == 0 {
+		req.errNoResponse()
+		req.release()
+		return
+	}
+
+	result := req.Result
+	fanin := make(chan *dns.Msg, len(targets))
+	for _, res := range targets {
+		sub := reqPool.Get().(*request)
+		*sub = *req
+		sub.Msg = req.Msg.Copy()
+		sub.Msg.Id = dns.Id()
+		sub.ID = sub.Msg.Id
+		sub.Result = fanin
+		res.query(sub)
+	}
+	// Every field needed by the racers has been copied onto its own sub-request,
+	// so the original can be returned to the pool immediately.
+	req.release()
+
+	go collectFaninResponses(result, fanin, len(targets))
+}
+
+// collectFaninResponses waits for the first valid answer among the racing resolvers,
+// forwards it to the caller's original result channel, and drains/discards the
+// remaining responses so their goroutines never block on a full channel. Every
+// racer has its own pooled *request, so this never touches an object that a
+// resolver's responses()/timeouts() path might concurrently release.
+func collectFaninResponses(result chan *dns.Msg, fanin chan *dns.Msg, expected int) {
+	var delivered bool
+
+	for i := 0; i < expected; i++ {
+		m := <-fanin
+		if delivered {
+			continue
+		}
+		if m == nil || m.Rcode == RcodeNoResponse || m.Rcode == dns.RcodeServerFailure {
+			continue
+		}
+		result <- m
+		delivered = true
+	}
+
+	if !delivered {
+		result <- nil
+	}
+}
+
+// pickParallelTargets returns up to n distinct, healthy resolvers for key, preferring
+// the resolvers with the lowest EWMA round-trip time and occasionally substituting a
+// random healthy resolver so recovered or under-explored upstreams stay in rotation.
+func (r *Resolvers) pickParallelTargets(n int, key string) []*resolver {
+	all := r.pool.AllResolvers()
+	healthy := make([]*resolver, 0, len(all))
+	for _, res := range all {
+		if res.Healthy() {
+			healthy = append(healthy, res)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	if len(healthy) <= n {
+		return healthy
+	}
+
+	sort.Slice(healthy, func(i, j int) bool {
+		return healthy[i].rtt.value() < healthy[j].rtt.value()
+	})
+
+	picked := append([]*resolver{}, healthy[:n]...)
+	if rand.Float64() < epsilonExplore {
+		picked[len(picked)-1] = healthy[n+rand.Intn(len(healthy)-n)]
+	}
+	return picked
+}
+
+// emaRTT tracks an exponential moving average of a resolver's round-trip time,
+// used to weight resolver choice in StrategyParallelBest.
+type emaRTT struct {
+	sync.Mutex
+	alpha   float64
+	avg     time.Duration
+	pending map[string]time.Time
+}
+
+func newEMARTT() *emaRTT {
+	return &emaRTT{alpha: 0.2, pending: make(map[string]time.Time)}
+}
+
+func rttKey(id uint16, name string) string {
+	return fmt.Sprintf("%s#%d", name, id)
+}
+
+func (e *emaRTT) start(id uint16, name string) {
+	e.Lock()
+	defer e.Unlock()
+
+	e.pending[rttKey(id, name)] = time.Now()
+}
+
+func (e *emaRTT) observe(id uint16, name string) time.Duration {
+	e.Lock()
+	defer e.Unlock()
+
+	key := rttKey(id, name)
+	sent, found := e.pending[key]
+	if !found {
+		return 0
+	}
+	delete(e.pending, key)
+
+	d := time.Since(sent)
+	if e.avg == 0 {
+		e.avg = d
+	} else {
+		e.avg = time.Duration(e.alpha*float64(d) + (1-e.alpha)*float64(e.avg))
+	}
+	return d
+}
+
+func (e *emaRTT) value() time.Duration {
+	e.Lock()
+	defer e.Unlock()
+
+	return e.avg
+}
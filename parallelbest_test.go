@@ -0,0 +1,144 @@
+// Copyright © by Jeff Foley 2017-2022. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package resolve
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestCollectFaninResponsesDeliversFirstValidAnswer(t *testing.T) {
+	fanin := make(chan *dns.Msg, 3)
+	result := make(chan *dns.Msg, 1)
+
+	servfail := new(dns.Msg)
+	servfail.Rcode = dns.RcodeServerFailure
+	good := new(dns.Msg)
+	good.Rcode = dns.RcodeSuccess
+
+	fanin <- servfail
+	fanin <- nil
+	fanin <- good
+
+	collectFaninResponses(result, fanin, 3)
+
+	select {
+	case m := <-result:
+		if m != good {
+			t.Fatalf("expected the only valid answer to be delivered, got %+v", m)
+		}
+	default:
+		t.Fatal("expected a delivered answer on the result channel")
+	}
+}
+
+func TestCollectFaninResponsesDeliversNilWhenAllFail(t *testing.T) {
+	fanin := make(chan *dns.Msg, 2)
+	result := make(chan *dns.Msg, 1)
+
+	servfail := new(dns.Msg)
+	servfail.Rcode = dns.RcodeServerFailure
+
+	fanin <- servfail
+	fanin <- nil
+
+	collectFaninResponses(result, fanin, 2)
+
+	select {
+	case m := <-result:
+		if m != nil {
+			t.Fatalf("expected nil when every racer failed, got %+v", m)
+		}
+	default:
+		t.Fatal("expected a nil answer on the result channel")
+	}
+}
+
+func TestCollectFaninResponsesDrainsStragglers(t *testing.T) {
+	fanin := make(chan *dns.Msg, 3)
+	result := make(chan *dns.Msg, 1)
+
+	good := new(dns.Msg)
+	good.Rcode = dns.RcodeSuccess
+	alsoGood := new(dns.Msg)
+	alsoGood.Rcode = dns.RcodeSuccess
+
+	fanin <- good
+	fanin <- alsoGood
+	fanin <- nil
+
+	done := make(chan struct{})
+	go func() {
+		collectFaninResponses(result, fanin, 3)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("collectFaninResponses did not drain every racer response")
+	}
+
+	if len(result) != 1 {
+		t.Fatalf("expected exactly one delivered answer, got %d", len(result))
+	}
+}
+
+func newTestResolverWithRTT(addr string, qps int, rtt time.Duration) *resolver {
+	res := newTestResolver(addr, qps)
+	res.rtt = newEMARTT()
+	res.rtt.avg = rtt
+	return res
+}
+
+func TestPickParallelTargetsReturnsAllWhenNotEnoughHealthy(t *testing.T) {
+	down := newTestResolverWithRTT("10.0.0.1:53", 10, 10*time.Millisecond)
+	down.SetHealthy(false)
+	a := newTestResolverWithRTT("10.0.0.2:53", 10, 10*time.Millisecond)
+	b := newTestResolverWithRTT("10.0.0.3:53", 10, 20*time.Millisecond)
+
+	r := &Resolvers{pool: &randomSelector{resolvers: []*resolver{down, a, b}}}
+
+	targets := r.pickParallelTargets(2, "www.example.com")
+	if len(targets) != 2 {
+		t.Fatalf("expected exactly the 2 healthy resolvers, got %d", len(targets))
+	}
+	for _, res := range targets {
+		if res == down {
+			t.Fatal("expected the unhealthy resolver to be excluded")
+		}
+	}
+}
+
+func TestPickParallelTargetsPrefersLowestRTT(t *testing.T) {
+	fastest := newTestResolverWithRTT("10.0.0.1:53", 10, 5*time.Millisecond)
+	resolvers := []*resolver{
+		fastest,
+		newTestResolverWithRTT("10.0.0.2:53", 10, 40*time.Millisecond),
+		newTestResolverWithRTT("10.0.0.3:53", 10, 60*time.Millisecond),
+		newTestResolverWithRTT("10.0.0.4:53", 10, 80*time.Millisecond),
+		newTestResolverWithRTT("10.0.0.5:53", 10, 100*time.Millisecond),
+	}
+	r := &Resolvers{pool: &randomSelector{resolvers: resolvers}}
+
+	for i := 0; i < 50; i++ {
+		targets := r.pickParallelTargets(2, "www.example.com")
+		if len(targets) != 2 {
+			t.Fatalf("expected 2 targets, got %d", len(targets))
+		}
+
+		var found bool
+		for _, res := range targets {
+			if res == fastest {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatal("expected the lowest-RTT resolver to always be among the picked targets")
+		}
+	}
+}
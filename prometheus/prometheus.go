@@ -0,0 +1,98 @@
+// Copyright © by Jeff Foley 2017-2022. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package resolveprom exports a resolve.Resolvers pool's Metrics snapshot to
+// Prometheus. It is kept in its own module-internal package so importing the core
+// resolve package never pulls in the Prometheus client library.
+package resolveprom
+
+import (
+	"time"
+
+	"github.com/caffix/resolve"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collector adapts a *resolve.Resolvers pool to the prometheus.Collector interface,
+// pulling a fresh Metrics snapshot on every scrape. Per-resolver RTT is not part of
+// this snapshot: it is pushed sample-by-sample into rtt via pool.SetRTTObserver, so
+// percentile/tail latency survives instead of collapsing into a single average.
+type collector struct {
+	pool *resolve.Resolvers
+	rtt  *prometheus.HistogramVec
+
+	queriesSent      *prometheus.Desc
+	responsesByRcode *prometheus.Desc
+	timeouts         *prometheus.Desc
+	tcpFallbacks     *prometheus.Desc
+	wildcardDetected *prometheus.Desc
+	queueDepth       *prometheus.Desc
+	resolverQueue    *prometheus.Desc
+}
+
+// RegisterPrometheus registers collectors for pool's metrics with reg. Call once per
+// pool; re-registering the same pool returns an error from reg.Register.
+func RegisterPrometheus(reg prometheus.Registerer, pool *resolve.Resolvers) error {
+	const ns = "resolve"
+
+	rtt := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: ns,
+		Name:      "resolver_rtt_seconds",
+		Help:      "Round-trip time of individual exchanges with a specific resolver.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"resolver"})
+	if err := reg.Register(rtt); err != nil {
+		return err
+	}
+	pool.SetRTTObserver(func(address string, d time.Duration) {
+		rtt.WithLabelValues(address).Observe(d.Seconds())
+	})
+
+	c := &collector{
+		pool: pool,
+		rtt:  rtt,
+		queriesSent: prometheus.NewDesc(
+			ns+"_queries_sent_total", "Total DNS queries sent by the pool.", nil, nil),
+		responsesByRcode: prometheus.NewDesc(
+			ns+"_responses_total", "DNS responses received, by RCODE.", []string{"rcode"}, nil),
+		timeouts: prometheus.NewDesc(
+			ns+"_timeouts_total", "Queries that never received a response in time.", nil, nil),
+		tcpFallbacks: prometheus.NewDesc(
+			ns+"_tcp_fallbacks_total", "Exchanges retried over TCP after a truncated UDP response.", nil, nil),
+		wildcardDetected: prometheus.NewDesc(
+			ns+"_wildcard_detected_total", "Names suppressed by wildcard detection.", nil, nil),
+		queueDepth: prometheus.NewDesc(
+			ns+"_queue_depth", "Number of requests waiting to be assigned to a resolver.", nil, nil),
+		resolverQueue: prometheus.NewDesc(
+			ns+"_resolver_queue_depth", "Number of requests queued for a specific resolver.", []string{"resolver"}, nil),
+	}
+	return reg.Register(c)
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.queriesSent
+	ch <- c.responsesByRcode
+	ch <- c.timeouts
+	ch <- c.tcpFallbacks
+	ch <- c.wildcardDetected
+	ch <- c.queueDepth
+	ch <- c.resolverQueue
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	m := c.pool.Metrics()
+
+	ch <- prometheus.MustNewConstMetric(c.queriesSent, prometheus.CounterValue, float64(m.QueriesSent))
+	ch <- prometheus.MustNewConstMetric(c.timeouts, prometheus.CounterValue, float64(m.Timeouts))
+	ch <- prometheus.MustNewConstMetric(c.tcpFallbacks, prometheus.CounterValue, float64(m.TCPFallbacks))
+	ch <- prometheus.MustNewConstMetric(c.wildcardDetected, prometheus.CounterValue, float64(m.WildcardDetected))
+	ch <- prometheus.MustNewConstMetric(c.queueDepth, prometheus.GaugeValue, float64(m.QueueDepth))
+
+	for rcode, count := range m.ResponsesByRcode {
+		ch <- prometheus.MustNewConstMetric(c.responsesByRcode, prometheus.CounterValue, float64(count), rcode)
+	}
+	for _, res := range m.Resolvers {
+		ch <- prometheus.MustNewConstMetric(c.resolverQueue, prometheus.GaugeValue, float64(res.QueueDepth), res.Address)
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright © by Jeff Foley 2017-2022. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package resolve
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var errExchangeTimeout = errors.New("the exchange timed out waiting for a response")
+
+// QueryLogEntry describes a single completed or failed DNS exchange.
+type QueryLogEntry struct {
+	Time       time.Time
+	ClientTag  string
+	Resolver   string
+	Qname      string
+	Qtype      string
+	Rcode      string
+	DurationMs int64
+	AnswerRRs  int
+	Truncated  bool
+	RetriedTCP bool
+	Error      string
+}
+
+// QueryLogger receives one QueryLogEntry per completed or failed exchange.
+type QueryLogger interface {
+	LogExchange(entry QueryLogEntry)
+}
+
+// noopQueryLogger is the default QueryLogger installed on a new Resolvers pool.
+type noopQueryLogger struct{}
+
+func (noopQueryLogger) LogExchange(QueryLogEntry) {}
+
+// SetQueryLogger installs l to receive an entry for every completed or failed exchange.
+// Logging happens off the hot path: entries are buffered on a channel and delivered by
+// a dedicated goroutine, so a slow logger cannot stall writeNextMsg or responses.
+func (r *Resolvers) SetQueryLogger(l QueryLogger) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.qlog != nil {
+		close(r.qlog.done)
+	}
+
+	if l == nil {
+		l = noopQueryLogger{}
+	}
+
+	ql := &queryLogSink{
+		logger:  l,
+		entries: make(chan QueryLogEntry, 1000),
+		done:    make(chan struct{}, 1),
+	}
+	go ql.run()
+	r.qlog = ql
+}
+
+func (r *Resolvers) logExchange(entry QueryLogEntry) {
+	r.metrics.observe(entry)
+
+	r.Lock()
+	ql := r.qlog
+	r.Unlock()
+
+	if ql == nil {
+		return
+	}
+
+	select {
+	case ql.entries <- entry:
+	default:
+		// The logger is falling behind; drop the entry rather than block the hot path.
+	}
+}
+
+// queryLogSink buffers entries on a channel and hands them to the configured
+// QueryLogger from a dedicated goroutine.
+type queryLogSink struct {
+	logger  QueryLogger
+	entries chan QueryLogEntry
+	done    chan struct{}
+}
+
+func (s *queryLogSink) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case entry := <-s.entries:
+			s.logger.LogExchange(entry)
+		}
+	}
+}
+
+// JSONLinesLogger writes one JSON-encoded QueryLogEntry per line to w.
+type JSONLinesLogger struct {
+	enc *json.Encoder
+}
+
+// NewJSONLinesLogger returns a QueryLogger that writes JSON-lines output to w.
+func NewJSONLinesLogger(w io.Writer) *JSONLinesLogger {
+	return &JSONLinesLogger{enc: json.NewEncoder(w)}
+}
+
+// LogExchange writes entry as a single line of JSON.
+func (l *JSONLinesLogger) LogExchange(entry QueryLogEntry) {
+	_ = l.enc.Encode(entry)
+}
+
+// CSVRotatingLogger writes QueryLogEntry rows as CSV, rotating to a new file named by
+// newWriter once per calendar day.
+type CSVRotatingLogger struct {
+	newWriter func(day string) (io.WriteCloser, error)
+	day       string
+	cur       io.WriteCloser
+	w         *csv.Writer
+}
+
+// NewCSVRotatingLogger returns a QueryLogger that asks newWriter for a fresh
+// destination whenever the calendar day (format "2006-01-02") changes.
+func NewCSVRotatingLogger(newWriter func(day string) (io.WriteCloser, error)) *CSVRotatingLogger {
+	return &CSVRotatingLogger{newWriter: newWriter}
+}
+
+var csvHeader = []string{
+	"time", "client_tag", "resolver", "qname", "qtype", "rcode",
+	"duration_ms", "answer_rrs", "truncated", "retried_tcp", "error",
+}
+
+// LogExchange appends entry as a CSV row, rotating the underlying file if the day changed.
+func (l *CSVRotatingLogger) LogExchange(entry QueryLogEntry) {
+	day := entry.Time.Format("2006-01-02")
+	if day != l.day || l.w == nil {
+		if err := l.rotate(day); err != nil {
+			return
+		}
+	}
+
+	_ = l.w.Write([]string{
+		entry.Time.Format(time.RFC3339),
+		entry.ClientTag,
+		entry.Resolver,
+		entry.Qname,
+		entry.Qtype,
+		entry.Rcode,
+		strconv.FormatInt(entry.DurationMs, 10),
+		strconv.Itoa(entry.AnswerRRs),
+		strconv.FormatBool(entry.Truncated),
+		strconv.FormatBool(entry.RetriedTCP),
+		entry.Error,
+	})
+	l.w.Flush()
+}
+
+func (l *CSVRotatingLogger) rotate(day string) error {
+	if l.cur != nil {
+		_ = l.cur.Close()
+	}
+
+	w, err := l.newWriter(day)
+	if err != nil {
+		return err
+	}
+
+	l.cur = w
+	l.w = csv.NewWriter(w)
+	l.day = day
+	return l.w.Write(csvHeader)
+}
+
+// logExchangeResult builds a QueryLogEntry for the completed or failed exchange of req
+// against r and hands it to the pool's configured QueryLogger.
+func (r *resolver) logExchangeResult(req *request, m *dns.Msg, d time.Duration, retriedTCP bool, exchangeErr error) {
+	if r.logEntry == nil {
+		return
+	}
+
+	entry := QueryLogEntry{
+		Time:       time.Now(),
+		Resolver:   r.address,
+		Qname:      req.Name,
+		Qtype:      dns.TypeToString[req.Qtype],
+		DurationMs: d.Milliseconds(),
+		RetriedTCP: retriedTCP,
+	}
+
+	if m != nil {
+		entry.Rcode = rcodeToLogString(m.Rcode)
+		entry.AnswerRRs = len(m.Answer)
+		entry.Truncated = m.Truncated
+	} else {
+		entry.Rcode = rcodeToLogString(RcodeNoResponse)
+	}
+	if exchangeErr != nil {
+		entry.Error = exchangeErr.Error()
+	}
+
+	r.logEntry(entry)
+}
+
+func rcodeToLogString(rcode int) string {
+	if s, ok := dns.RcodeToString[rcode]; ok {
+		return s
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
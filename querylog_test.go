@@ -0,0 +1,83 @@
+// Copyright © by Jeff Foley 2017-2022. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package resolve
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONLinesLoggerWritesOneLinePerEntry(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewJSONLinesLogger(&buf)
+
+	l.LogExchange(QueryLogEntry{Resolver: "10.0.0.1:53", Qname: "www.example.com", Rcode: "NOERROR"})
+	l.LogExchange(QueryLogEntry{Resolver: "10.0.0.2:53", Qname: "api.example.com", Rcode: "NXDOMAIN"})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 JSON lines, got %d", len(lines))
+	}
+
+	var first QueryLogEntry
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("expected valid JSON, got error: %v", err)
+	}
+	if first.Qname != "www.example.com" || first.Rcode != "NOERROR" {
+		t.Fatalf("unexpected decoded entry: %+v", first)
+	}
+}
+
+type nopWriteCloser struct {
+	*bytes.Buffer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestCSVRotatingLoggerRotatesOnDayChange(t *testing.T) {
+	var opened []string
+	buffers := make(map[string]*bytes.Buffer)
+
+	l := NewCSVRotatingLogger(func(day string) (io.WriteCloser, error) {
+		opened = append(opened, day)
+		buf := new(bytes.Buffer)
+		buffers[day] = buf
+		return nopWriteCloser{buf}, nil
+	})
+
+	day1 := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	day2 := time.Date(2026, 1, 2, 1, 0, 0, 0, time.UTC)
+
+	l.LogExchange(QueryLogEntry{Time: day1, Qname: "www.example.com"})
+	l.LogExchange(QueryLogEntry{Time: day1, Qname: "api.example.com"})
+	l.LogExchange(QueryLogEntry{Time: day2, Qname: "cdn.example.com"})
+
+	if len(opened) != 2 {
+		t.Fatalf("expected a new file to be opened once per calendar day, got %d opens: %v", len(opened), opened)
+	}
+
+	first := buffers["2026-01-01"].String()
+	if strings.Count(first, "\n") != 3 {
+		t.Fatalf("expected a header row plus 2 data rows in the first day's file, got:\n%s", first)
+	}
+	if !strings.Contains(first, "www.example.com") || !strings.Contains(first, "api.example.com") {
+		t.Fatalf("expected both same-day entries in the first file, got:\n%s", first)
+	}
+
+	second := buffers["2026-01-02"].String()
+	if !strings.Contains(second, "cdn.example.com") {
+		t.Fatalf("expected the new-day entry in the second file, got:\n%s", second)
+	}
+}
+
+func TestRcodeToLogStringUnknownRcode(t *testing.T) {
+	if got := rcodeToLogString(4096); got != "RCODE4096" {
+		t.Fatalf("expected an unknown RCODE to fall back to a numeric label, got %q", got)
+	}
+}
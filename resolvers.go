@@ -6,11 +6,13 @@ package resolve
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"io/ioutil"
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/caffix/queue"
@@ -21,18 +23,23 @@ import (
 // Resolvers is a pool of DNS resolvers managed for brute forcing using random selection.
 type Resolvers struct {
 	sync.Mutex
-	done      chan struct{}
-	log       *log.Logger
-	pool      selector
-	rmap      map[string]struct{}
-	wildcards map[string]*wildcard
-	queue     queue.Queue
-	qps       int
-	maxSet    bool
-	rate      ratelimit.Limiter
-	detector  *resolver
-	timeout   time.Duration
-	options   *ThresholdOptions
+	done        chan struct{}
+	log         *log.Logger
+	pool        Selector
+	rmap        map[string]struct{}
+	wildcards   map[string]*wildcard
+	queue       queue.Queue
+	qps         int
+	maxSet      bool
+	rate        ratelimit.Limiter
+	detector    *resolver
+	timeout     time.Duration
+	options     *ThresholdOptions
+	strategy    QueryStrategy
+	parallelN   int
+	qlog        *queryLogSink
+	metrics     *poolMetrics
+	rttObserver RTTObserverFunc
 }
 
 type resolver struct {
@@ -40,11 +47,30 @@ type resolver struct {
 	xchgQueue queue.Queue
 	xchgs     *xchgMgr
 	address   string
+	proto     Protocol
 	qps       int
 	inc       time.Duration
 	next      time.Time
-	conn      *dns.Conn
+	conn      xfrConn
 	stats     *stats
+	healthy   int32
+	rtt       *emaRTT
+	logEntry  func(QueryLogEntry)
+	onRTT     func(time.Duration)
+}
+
+// Healthy reports whether the resolver is currently eligible for selection.
+func (r *resolver) Healthy() bool {
+	return atomic.LoadInt32(&r.healthy) != 0
+}
+
+// SetHealthy updates whether the resolver is currently eligible for selection.
+func (r *resolver) SetHealthy(up bool) {
+	var v int32
+	if up {
+		v = 1
+	}
+	atomic.StoreInt32(&r.healthy, v)
 }
 
 // NewResolvers initializes a Resolvers that starts with the provided list of DNS resolver IP addresses.
@@ -58,8 +84,11 @@ func NewResolvers() *Resolvers {
 		queue:     queue.NewQueue(),
 		timeout:   DefaultTimeout,
 		options:   new(ThresholdOptions),
+		qlog:      &queryLogSink{logger: noopQueryLogger{}, entries: make(chan QueryLogEntry, 1000), done: make(chan struct{}, 1)},
+		metrics:   newPoolMetrics(),
 	}
 
+	go r.qlog.run()
 	go r.enforceMaxQPS()
 	go r.sendQueries()
 	go r.thresholdChecks()
@@ -76,6 +105,15 @@ func (r *Resolvers) SetLogger(l *log.Logger) {
 	r.log = l
 }
 
+// SetSelector replaces the strategy used to choose a resolver for each outgoing query.
+// It must be called before any resolvers are added with AddResolvers.
+func (r *Resolvers) SetSelector(sel Selector) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.pool = sel
+}
+
 // SetTimeout updates the amount of time this pool will wait for response messages.
 func (r *Resolvers) SetTimeout(d time.Duration) {
 	r.Lock()
@@ -96,6 +134,9 @@ func (r *Resolvers) updateResolverTimeouts() {
 		case <-res.done:
 		default:
 			res.xchgs.setTimeout(r.timeout)
+			if ts, ok := res.conn.(timeoutSetter); ok {
+				ts.SetTimeout(r.timeout)
+			}
 		}
 	}
 }
@@ -121,7 +162,17 @@ func (r *Resolvers) SetMaxQPS(qps int) {
 }
 
 // AddResolvers initializes and adds new resolvers to the pool of resolvers.
+// The resolvers added through this method communicate over plain UDP, on port 53
+// unless an address names another port.
 func (r *Resolvers) AddResolvers(qps int, addrs ...string) error {
+	return r.AddResolversWithProto(ProtoUDP, qps, nil, addrs...)
+}
+
+// AddResolversWithProto initializes and adds new resolvers that communicate using the
+// specified transport protocol. ProtoTCPTLS performs DNS-over-TLS (RFC 7858) and
+// ProtoDoH performs DNS-over-HTTPS (RFC 8484); tlsConfig is used for both and may be nil
+// to accept the default configuration. For ProtoDoH, addrs are the HTTPS endpoint URLs.
+func (r *Resolvers) AddResolversWithProto(proto Protocol, qps int, tlsConfig *tls.Config, addrs ...string) error {
 	r.Lock()
 	defer r.Unlock()
 
@@ -133,7 +184,7 @@ func (r *Resolvers) AddResolvers(qps int, addrs ...string) error {
 		if _, found := r.rmap[addr]; found {
 			continue
 		}
-		if res := r.initializeResolver(addr, qps); res != nil {
+		if res := r.initializeResolver(addr, proto, qps, tlsConfig); res != nil {
 			r.rmap[addr] = struct{}{}
 			r.pool.AddResolver(res)
 			if !r.maxSet {
@@ -166,6 +217,7 @@ func (r *Resolvers) Stop() {
 		res.stop()
 	}
 	r.pool.Close()
+	close(r.qlog.done)
 }
 
 // Query queues the provided DNS message and returns the response on the provided channel.
@@ -238,7 +290,11 @@ func (r *Resolvers) enforceMaxQPS() {
 				continue
 			}
 			if req, ok := e.(*request); ok {
-				if res := r.pool.GetResolver(); res != nil {
+				if r.strategy == StrategyParallelBest {
+					r.dispatchParallelBest(req)
+					continue
+				}
+				if res := r.pool.GetResolver(req.Name); res != nil {
 					res.query(req)
 					continue
 				}
@@ -291,31 +347,58 @@ func (r *Resolvers) checkAllQueues() bool {
 	return sent
 }
 
-func (r *Resolvers) initializeResolver(addr string, qps int) *resolver {
+func (r *Resolvers) initializeResolver(addr string, proto Protocol, qps int, tlsConfig *tls.Config) *resolver {
+	var conn xfrConn
+	switch proto {
+	case ProtoTCPTLS:
+		conn = dialDoTConn(addr, tlsConfig)
+	case ProtoDoH:
+		conn = newDoHConn(addr, tlsConfig, r.timeout)
+	default:
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			// Keep the default port on the address so the TCP fallback can dial it
+			addr = net.JoinHostPort(addr, "53")
+		}
+		conn = dialUDPConn(addr)
+	}
+	if conn == nil {
+		return nil
+	}
+
+	res := &resolver{
+		done:      make(chan struct{}, 1),
+		xchgQueue: queue.NewQueue(),
+		xchgs:     newXchgMgr(r.timeout),
+		address:   addr,
+		proto:     proto,
+		qps:       qps,
+		inc:       time.Second / time.Duration(qps),
+		next:      time.Now(),
+		conn:      conn,
+		stats:     new(stats),
+		healthy:   1,
+		rtt:       newEMARTT(),
+		logEntry:  r.logExchange,
+	}
+	res.onRTT = func(d time.Duration) { r.observeRTT(addr, d) }
+	go res.responses()
+	go res.timeouts()
+	return res
+}
+
+func dialUDPConn(addr string) xfrConn {
 	if _, _, err := net.SplitHostPort(addr); err != nil {
 		// Add the default port number to the IP address
 		addr = net.JoinHostPort(addr, "53")
 	}
 
-	var res *resolver
 	c := dns.Client{UDPSize: dns.DefaultMsgSize}
-	if conn, err := c.Dial(addr); err == nil {
-		_ = conn.SetDeadline(time.Time{})
-		res = &resolver{
-			done:      make(chan struct{}, 1),
-			xchgQueue: queue.NewQueue(),
-			xchgs:     newXchgMgr(r.timeout),
-			address:   addr,
-			qps:       qps,
-			inc:       time.Second / time.Duration(qps),
-			next:      time.Now(),
-			conn:      conn,
-			stats:     new(stats),
-		}
-		go res.responses()
-		go res.timeouts()
+	conn, err := c.Dial(addr)
+	if err != nil {
+		return nil
 	}
-	return res
+	_ = conn.SetDeadline(time.Time{})
+	return conn
 }
 
 func (r *resolver) stop() {
@@ -369,6 +452,7 @@ func (r *resolver) writeNextMsg() {
 		if err := r.conn.WriteMsg(req.Msg); err == nil && r.xchgs.add(req) == nil {
 			// Set the timestamp for message expiration
 			r.xchgs.updateTimestamp(req.ID, req.Name)
+			r.rtt.start(req.ID, req.Name)
 			// Update the time for the next query to be sent
 			r.next = time.Now().Add(r.inc)
 			return
@@ -390,19 +474,33 @@ func (r *resolver) responses() {
 		_ = r.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
 		if m, err := r.conn.ReadMsg(); err == nil && m != nil && len(m.Question) > 0 {
 			if req := r.xchgs.remove(m.Id, m.Question[0].Name); req != nil {
-				if m.Truncated {
+				d := r.rtt.observe(m.Id, m.Question[0].Name)
+				if r.onRTT != nil {
+					r.onRTT(d)
+				}
+				if m.Truncated && r.proto == ProtoUDP {
+					// Only plain UDP needs a TCP retry: DoT/DoH already run over a
+					// reliable stream, and reusing r.conn here would race with this
+					// same ReadMsg loop on the one connection both would share.
 					go r.tcpExchange(req)
 					continue
 				}
 				req.Result <- m
 				r.collectStats(m)
+				r.logExchangeResult(req, m, d, false, nil)
 				req.release()
 			}
 		}
 	}
 }
 
+// tcpExchange re-issues a truncated UDP response over a dedicated plain-TCP
+// connection. It is only ever called for ProtoUDP resolvers: DoT/DoH already run
+// over a reliable stream sharing r.conn with this resolver's responses() read loop,
+// so retrying on r.conn itself would mean two goroutines reading the same connection.
 func (r *resolver) tcpExchange(req *request) {
+	start := time.Now()
+
 	client := dns.Client{
 		Net:     "tcp",
 		Timeout: time.Minute,
@@ -410,8 +508,10 @@ func (r *resolver) tcpExchange(req *request) {
 	if m, _, err := client.Exchange(req.Msg, r.address); err == nil {
 		req.Result <- m
 		r.collectStats(m)
+		r.logExchangeResult(req, m, time.Since(start), true, nil)
 	} else {
 		req.errNoResponse()
+		r.logExchangeResult(req, nil, time.Since(start), true, err)
 	}
 	req.release()
 }
@@ -428,6 +528,7 @@ func (r *resolver) timeouts() {
 			for _, req := range r.xchgs.removeExpired() {
 				req.errNoResponse()
 				r.collectStats(req.Msg)
+				r.logExchangeResult(req, nil, r.timeout, false, errExchangeTimeout)
 				req.release()
 			}
 		}
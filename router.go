@@ -0,0 +1,163 @@
+// Copyright © by Jeff Foley 2017-2022. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package resolve
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// route associates a domain suffix with the resolver pool that should handle it,
+// along with any per-suffix overrides requested through UpstreamOptions.
+type route struct {
+	suffix string
+	pool   *Resolvers
+	opts   *UpstreamOptions
+}
+
+// UpstreamOptions holds settings passed with a suffix to Router.Upstream. QPS and
+// Timeout are applied to the registered pool itself, so they take effect for every
+// query that pool handles, including queries routed to it under other suffixes.
+type UpstreamOptions struct {
+	// QPS, when non-zero, replaces the pool's configured maximum queries per second.
+	QPS int
+	// Timeout, when non-zero, replaces the pool's configured response timeout.
+	Timeout time.Duration
+	// BypassWildcardDetection marks names matching this suffix as exempt from wildcard
+	// filtering. The Router only reports it through BypassesWildcard; it filters nothing.
+	BypassWildcardDetection bool
+}
+
+// Router dispatches queries to one of several resolver pools based on a longest-suffix
+// match of the question name, giving callers a conditional/split-horizon upstream
+// without having to demultiplex queries themselves.
+type Router struct {
+	sync.RWMutex
+	routes       []*route
+	wildcard     *Resolvers
+	wildcardOpts *UpstreamOptions
+}
+
+// NewRouter returns an empty Router. At least one upstream should be registered for
+// the "." suffix so every query has somewhere to go.
+func NewRouter() *Router {
+	return new(Router)
+}
+
+// Upstream registers pool as the resolver used for names within suffix. The suffix "."
+// matches every name and is typically used to register the default/public resolver.
+// opts may be nil to accept pool's own settings unmodified.
+func (rt *Router) Upstream(suffix string, pool *Resolvers, opts *UpstreamOptions) {
+	rt.Lock()
+	defer rt.Unlock()
+
+	suffix = strings.ToLower(RemoveLastDot(suffix))
+	if opts != nil {
+		rt.applyOptions(pool, opts)
+	}
+	if suffix == "" {
+		rt.wildcard = pool
+		rt.wildcardOpts = opts
+		return
+	}
+
+	for _, r := range rt.routes {
+		if r.suffix == suffix {
+			r.pool = pool
+			r.opts = opts
+			return
+		}
+	}
+
+	rt.routes = append(rt.routes, &route{suffix: suffix, pool: pool, opts: opts})
+	sort.Slice(rt.routes, func(i, j int) bool {
+		return len(rt.routes[i].suffix) > len(rt.routes[j].suffix)
+	})
+}
+
+func (rt *Router) applyOptions(pool *Resolvers, opts *UpstreamOptions) {
+	if opts.QPS != 0 {
+		pool.SetMaxQPS(opts.QPS)
+	}
+	if opts.Timeout != 0 {
+		pool.SetTimeout(opts.Timeout)
+	}
+}
+
+// BypassesWildcard reports whether the suffix matching name was registered with
+// BypassWildcardDetection, so callers can skip the detector for that upstream.
+func (rt *Router) BypassesWildcard(name string) bool {
+	rt.RLock()
+	defer rt.RUnlock()
+
+	name = strings.ToLower(RemoveLastDot(name))
+	for _, r := range rt.routes {
+		if name == r.suffix || strings.HasSuffix(name, "."+r.suffix) {
+			return r.opts != nil && r.opts.BypassWildcardDetection
+		}
+	}
+	if rt.wildcardOpts != nil {
+		return rt.wildcardOpts.BypassWildcardDetection
+	}
+	return false
+}
+
+// poolFor returns the resolver pool registered for name using longest-suffix match,
+// falling back to the wildcard upstream registered for "." when nothing else matches.
+func (rt *Router) poolFor(name string) *Resolvers {
+	rt.RLock()
+	defer rt.RUnlock()
+
+	name = strings.ToLower(RemoveLastDot(name))
+	for _, r := range rt.routes {
+		if name == r.suffix || strings.HasSuffix(name, "."+r.suffix) {
+			return r.pool
+		}
+	}
+	return rt.wildcard
+}
+
+// Query routes the provided DNS message to the resolver pool registered for its
+// question name and returns the response on the provided channel.
+func (rt *Router) Query(ctx context.Context, msg *dns.Msg, ch chan *dns.Msg) {
+	if msg == nil || len(msg.Question) == 0 {
+		ch <- msg
+		return
+	}
+
+	pool := rt.poolFor(msg.Question[0].Name)
+	if pool == nil {
+		msg.Rcode = RcodeNoResponse
+		ch <- msg
+		return
+	}
+	pool.Query(ctx, msg, ch)
+}
+
+// QueryChan routes the provided DNS message and sends the response on the returned channel.
+func (rt *Router) QueryChan(ctx context.Context, msg *dns.Msg) chan *dns.Msg {
+	ch := make(chan *dns.Msg, 1)
+	rt.Query(ctx, msg, ch)
+	return ch
+}
+
+// QueryBlocking routes the provided DNS message and returns the associated response message.
+func (rt *Router) QueryBlocking(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	if msg == nil || len(msg.Question) == 0 {
+		return msg, errors.New("the message has no question")
+	}
+
+	pool := rt.poolFor(msg.Question[0].Name)
+	if pool == nil {
+		return msg, errors.New("no resolver pool is registered for this name")
+	}
+	return pool.QueryBlocking(ctx, msg)
+}
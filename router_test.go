@@ -0,0 +1,82 @@
+// Copyright © by Jeff Foley 2017-2022. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package resolve
+
+import "testing"
+
+func TestRouterPoolForLongestSuffixMatch(t *testing.T) {
+	rt := NewRouter()
+	public := new(Resolvers)
+	internal := new(Resolvers)
+	corp := new(Resolvers)
+
+	rt.Upstream(".", public, nil)
+	rt.Upstream("internal", internal, nil)
+	rt.Upstream("corp.internal", corp, nil)
+
+	cases := []struct {
+		name string
+		want *Resolvers
+	}{
+		{"www.example.com", public},
+		{"host.internal", internal},
+		{"host.corp.internal", corp},
+		{"corp.internal", corp},
+	}
+
+	for _, c := range cases {
+		if got := rt.poolFor(c.name); got != c.want {
+			t.Errorf("poolFor(%q): expected the longest-suffix match, got a different pool", c.name)
+		}
+	}
+}
+
+func TestRouterPoolForNoWildcardRegistered(t *testing.T) {
+	rt := NewRouter()
+	internal := new(Resolvers)
+	rt.Upstream("internal", internal, nil)
+
+	if got := rt.poolFor("www.example.com"); got != nil {
+		t.Fatalf("expected no pool when nothing matches and no wildcard is registered, got %v", got)
+	}
+}
+
+func TestRouterBypassesWildcardOnSuffixRoute(t *testing.T) {
+	rt := NewRouter()
+	internal := new(Resolvers)
+	rt.Upstream("internal", internal, &UpstreamOptions{BypassWildcardDetection: true})
+
+	if !rt.BypassesWildcard("host.internal") {
+		t.Fatal("expected the suffix route's BypassWildcardDetection to apply")
+	}
+	if rt.BypassesWildcard("host.example.com") {
+		t.Fatal("expected no bypass for a name matching no registered suffix")
+	}
+}
+
+func TestRouterBypassesWildcardOnDefaultRoute(t *testing.T) {
+	rt := NewRouter()
+	public := new(Resolvers)
+	rt.Upstream(".", public, &UpstreamOptions{BypassWildcardDetection: true})
+
+	if !rt.BypassesWildcard("www.example.com") {
+		t.Fatal("expected BypassWildcardDetection registered on the default (\".\") upstream to apply")
+	}
+}
+
+func TestRouterBypassesWildcardSuffixTakesPrecedenceOverDefault(t *testing.T) {
+	rt := NewRouter()
+	public := new(Resolvers)
+	internal := new(Resolvers)
+	rt.Upstream(".", public, &UpstreamOptions{BypassWildcardDetection: true})
+	rt.Upstream("internal", internal, &UpstreamOptions{BypassWildcardDetection: false})
+
+	if rt.BypassesWildcard("host.internal") {
+		t.Fatal("expected the more specific suffix route's option to win over the default route's")
+	}
+	if !rt.BypassesWildcard("www.example.com") {
+		t.Fatal("expected the default route's option to still apply to names matching no suffix route")
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright © by Jeff Foley 2017-2022. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package resolve
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Selector chooses which resolver in a pool should handle the next outgoing query.
+// Implementations must be safe for concurrent use and must skip unhealthy resolvers.
+type Selector interface {
+	// AddResolver registers a new resolver with the selector.
+	AddResolver(res *resolver)
+	// AllResolvers returns every resolver currently registered, healthy or not.
+	AllResolvers() []*resolver
+	// GetResolver returns the resolver to use for the given request key (typically
+	// the qname), or nil if none are healthy. Selectors that do not use the key,
+	// such as random or round-robin, may ignore it.
+	GetResolver(key string) *resolver
+	// Len returns the number of resolvers currently registered.
+	Len() int
+	// Close releases resources held by the selector and its resolvers.
+	Close()
+}
+
+// randomSelector picks a resolver uniformly at random from the healthy set.
+type randomSelector struct {
+	sync.Mutex
+	resolvers []*resolver
+}
+
+func (s *randomSelector) AddResolver(res *resolver) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.resolvers = append(s.resolvers, res)
+}
+
+func (s *randomSelector) AllResolvers() []*resolver {
+	s.Lock()
+	defer s.Unlock()
+
+	all := make([]*resolver, len(s.resolvers))
+	copy(all, s.resolvers)
+	return all
+}
+
+func (s *randomSelector) GetResolver(key string) *resolver {
+	s.Lock()
+	defer s.Unlock()
+
+	healthy := healthyResolvers(s.resolvers)
+	if len(healthy) == 0 {
+		return nil
+	}
+	return healthy[rand.Intn(len(healthy))]
+}
+
+func (s *randomSelector) Len() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return len(s.resolvers)
+}
+
+func (s *randomSelector) Close() {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, res := range s.resolvers {
+		res.stop()
+	}
+	s.resolvers = nil
+}
+
+// roundRobinSelector cycles through the healthy resolvers in registration order,
+// spreading load evenly across the pool.
+type roundRobinSelector struct {
+	sync.Mutex
+	next      int
+	resolvers []*resolver
+}
+
+// NewRoundRobinSelector returns a Selector that distributes queries evenly across
+// the healthy resolvers in the pool, in the order they were added.
+func NewRoundRobinSelector() Selector {
+	return new(roundRobinSelector)
+}
+
+func (s *roundRobinSelector) AddResolver(res *resolver) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.resolvers = append(s.resolvers, res)
+}
+
+func (s *roundRobinSelector) AllResolvers() []*resolver {
+	s.Lock()
+	defer s.Unlock()
+
+	all := make([]*resolver, len(s.resolvers))
+	copy(all, s.resolvers)
+	return all
+}
+
+func (s *roundRobinSelector) GetResolver(key string) *resolver {
+	s.Lock()
+	defer s.Unlock()
+
+	healthy := healthyResolvers(s.resolvers)
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	s.next = (s.next + 1) % len(healthy)
+	return healthy[s.next]
+}
+
+func (s *roundRobinSelector) Len() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return len(s.resolvers)
+}
+
+func (s *roundRobinSelector) Close() {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, res := range s.resolvers {
+		res.stop()
+	}
+	s.resolvers = nil
+}
+
+// hrwSelector implements weighted rendezvous (highest-random-weight) hashing: for a
+// given request key, every resolver is scored and the highest score wins. The same
+// key is therefore sticky to the same resolver, and adding or removing a resolver
+// only reshuffles the 1/N of keys that mapped to it, unlike modulo hashing.
+type hrwSelector struct {
+	sync.Mutex
+	resolvers []*resolver
+}
+
+// NewHRWSelector returns a Selector that routes each request key (typically the
+// qname) to the same resolver whenever possible, weighted by each resolver's QPS.
+func NewHRWSelector() Selector {
+	return new(hrwSelector)
+}
+
+func (s *hrwSelector) AddResolver(res *resolver) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.resolvers = append(s.resolvers, res)
+}
+
+func (s *hrwSelector) AllResolvers() []*resolver {
+	s.Lock()
+	defer s.Unlock()
+
+	all := make([]*resolver, len(s.resolvers))
+	copy(all, s.resolvers)
+	return all
+}
+
+// GetResolver returns the healthy resolver with the highest rendezvous score for
+// key, so repeated lookups of the same key land on the same resolver.
+func (s *hrwSelector) GetResolver(key string) *resolver {
+	s.Lock()
+	all := make([]*resolver, len(s.resolvers))
+	copy(all, s.resolvers)
+	s.Unlock()
+
+	var best *resolver
+	var bestScore float64
+
+	for _, res := range all {
+		if !res.Healthy() {
+			continue
+		}
+
+		score := hrwScore(key, res)
+		if best == nil || score > bestScore {
+			best = res
+			bestScore = score
+		}
+	}
+	return best
+}
+
+func hrwScore(key string, res *resolver) float64 {
+	weight := float64(res.qps)
+	if weight <= 0 {
+		weight = 1
+	}
+
+	h := xxhash.Sum64String(key + "|" + res.address)
+	// Map the hash to a uniform value u in (0, 1); -ln(u) is then exponentially
+	// distributed, and dividing the weight by it makes higher-QPS resolvers tend to
+	// score higher across many keys.
+	u := (float64(h) + 1) / (float64(math.MaxUint64) + 2)
+	return weight / -math.Log(u)
+}
+
+func (s *hrwSelector) Len() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return len(s.resolvers)
+}
+
+func (s *hrwSelector) Close() {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, res := range s.resolvers {
+		res.stop()
+	}
+	s.resolvers = nil
+}
+
+func healthyResolvers(all []*resolver) []*resolver {
+	healthy := make([]*resolver, 0, len(all))
+	for _, res := range all {
+		if res.Healthy() {
+			healthy = append(healthy, res)
+		}
+	}
+	return healthy
+}
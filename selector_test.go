@@ -0,0 +1,74 @@
+// Copyright © by Jeff Foley 2017-2022. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package resolve
+
+import "testing"
+
+func newTestResolver(addr string, qps int) *resolver {
+	return &resolver{address: addr, qps: qps, healthy: 1}
+}
+
+func TestHRWSelectorKeyAffinity(t *testing.T) {
+	s := &hrwSelector{resolvers: []*resolver{
+		newTestResolver("10.0.0.1:53", 10),
+		newTestResolver("10.0.0.2:53", 10),
+		newTestResolver("10.0.0.3:53", 10),
+	}}
+
+	first := s.GetResolver("www.example.com")
+	for i := 0; i < 20; i++ {
+		if got := s.GetResolver("www.example.com"); got != first {
+			t.Fatalf("expected the same key to stay sticky to %s, got %s", first.address, got.address)
+		}
+	}
+}
+
+func TestHRWSelectorDistributesAcrossKeys(t *testing.T) {
+	s := &hrwSelector{resolvers: []*resolver{
+		newTestResolver("10.0.0.1:53", 10),
+		newTestResolver("10.0.0.2:53", 10),
+		newTestResolver("10.0.0.3:53", 10),
+	}}
+
+	seen := make(map[string]struct{})
+	for i := 0; i < 200; i++ {
+		key := "host" + string(rune('a'+i%26)) + string(rune(i)) + ".example.com"
+		res := s.GetResolver(key)
+		if res == nil {
+			t.Fatal("expected a resolver for every key")
+		}
+		seen[res.address] = struct{}{}
+	}
+
+	if len(seen) < 2 {
+		t.Fatalf("expected traffic spread across more than one resolver, got only %v", seen)
+	}
+}
+
+func TestHRWSelectorSkipsUnhealthy(t *testing.T) {
+	down := newTestResolver("10.0.0.1:53", 10)
+	down.SetHealthy(false)
+	up := newTestResolver("10.0.0.2:53", 10)
+
+	s := &hrwSelector{resolvers: []*resolver{down, up}}
+
+	for i := 0; i < 20; i++ {
+		key := "www.example.com" + string(rune(i))
+		if got := s.GetResolver(key); got != up {
+			t.Fatalf("expected the unhealthy resolver to never be selected, got %s", got.address)
+		}
+	}
+}
+
+func TestHRWSelectorNoHealthyResolvers(t *testing.T) {
+	down := newTestResolver("10.0.0.1:53", 10)
+	down.SetHealthy(false)
+
+	s := &hrwSelector{resolvers: []*resolver{down}}
+
+	if got := s.GetResolver("www.example.com"); got != nil {
+		t.Fatalf("expected nil when no resolvers are healthy, got %s", got.address)
+	}
+}
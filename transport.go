@@ -0,0 +1,207 @@
+// Copyright © by Jeff Foley 2017-2022. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// timeoutSetter is implemented by xfrConn transports whose per-exchange timeout can
+// be updated after creation, so Resolvers.SetTimeout stays uniform across transports.
+type timeoutSetter interface {
+	SetTimeout(d time.Duration)
+}
+
+// Protocol identifies the transport a resolver uses to exchange DNS messages with its upstream.
+type Protocol int
+
+const (
+	// ProtoUDP performs plain DNS over UDP, falling back to TCP when a response is truncated.
+	ProtoUDP Protocol = iota
+	// ProtoTCPTLS performs DNS-over-TLS (RFC 7858) over a long-lived, pipelined connection.
+	ProtoTCPTLS
+	// ProtoDoH performs DNS-over-HTTPS (RFC 8484) by POSTing the wire-format message.
+	ProtoDoH
+)
+
+// xfrConn is the read/write path a resolver uses to exchange messages with its upstream,
+// satisfied by *dns.Conn as well as the DoT and DoH implementations below.
+type xfrConn interface {
+	WriteMsg(m *dns.Msg) error
+	ReadMsg() (*dns.Msg, error)
+	SetReadDeadline(t time.Time) error
+	Close() error
+}
+
+// dotConn is a pipelined DNS-over-TLS connection: writes go straight to the TLS
+// connection, and reads are serialized through the single underlying dns.Conn.
+type dotConn struct {
+	*dns.Conn
+}
+
+func dialDoTConn(addr string, tlsConfig *tls.Config) xfrConn {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "853")
+	}
+
+	cfg := tlsConfig
+	if cfg == nil {
+		cfg = new(tls.Config)
+	}
+
+	c := dns.Client{Net: "tcp-tls", TLSConfig: cfg}
+	conn, err := c.Dial(addr)
+	if err != nil {
+		return nil
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return &dotConn{Conn: conn}
+}
+
+// PinnedSPKI returns a tls.Config that, in addition to normal certificate validation,
+// requires the SPKI SHA-256 digest of a certificate in the presented chain to match one
+// of the provided pins.
+func PinnedSPKI(serverName string, pins ...[32]byte) *tls.Config {
+	cfg := &tls.Config{ServerName: serverName}
+	cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				continue
+			}
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if bytes.Equal(sum[:], pin[:]) {
+					return nil
+				}
+			}
+		}
+		return errors.New("resolve: no certificate in the chain matched a pinned SPKI")
+	}
+	return cfg
+}
+
+// dohConn adapts a DNS-over-HTTPS endpoint to the xfrConn interface. Each WriteMsg
+// performs the POST asynchronously and the result is delivered to a later ReadMsg,
+// keeping the same write-then-read pattern the UDP and DoT paths rely on. Every POST
+// is bound to a context derived from the pool's configured timeout, so a stalled
+// upstream is aborted instead of leaking a goroutine and socket until the HTTP
+// client's own fixed deadline eventually fires.
+type dohConn struct {
+	client    *http.Client
+	url       string
+	results   chan *dns.Msg
+	done      <-chan struct{}
+	cancel    context.CancelFunc
+	timeoutNs int64
+}
+
+func newDoHConn(url string, tlsConfig *tls.Config, timeout time.Duration) xfrConn {
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &dohConn{
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		url:       url,
+		results:   make(chan *dns.Msg, 1),
+		done:      ctx.Done(),
+		cancel:    cancel,
+		timeoutNs: int64(timeout),
+	}
+}
+
+// SetTimeout updates the per-exchange deadline applied to future POSTs.
+func (d *dohConn) SetTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	atomic.StoreInt64(&d.timeoutNs, int64(timeout))
+}
+
+func (d *dohConn) WriteMsg(m *dns.Msg) error {
+	packed, err := m.Pack()
+	if err != nil {
+		return err
+	}
+
+	timeout := time.Duration(atomic.LoadInt64(&d.timeoutNs))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+	go func() {
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(packed))
+		if err != nil {
+			d.deliver(nil)
+			return
+		}
+		req.Header.Set("Content-Type", "application/dns-message")
+
+		resp, err := d.client.Do(req)
+		if err != nil {
+			d.deliver(nil)
+			return
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			d.deliver(nil)
+			return
+		}
+
+		answer := new(dns.Msg)
+		if err := answer.Unpack(body); err != nil {
+			d.deliver(nil)
+			return
+		}
+		d.deliver(answer)
+	}()
+	return nil
+}
+
+func (d *dohConn) deliver(m *dns.Msg) {
+	select {
+	case <-d.done:
+	case d.results <- m:
+	}
+}
+
+func (d *dohConn) ReadMsg() (*dns.Msg, error) {
+	select {
+	case <-d.done:
+		return nil, errors.New("resolve: the DoH connection has been closed")
+	case m := <-d.results:
+		if m == nil {
+			return nil, errors.New("resolve: the DoH exchange failed")
+		}
+		return m, nil
+	}
+}
+
+func (d *dohConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (d *dohConn) Close() error {
+	d.cancel()
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright © by Jeff Foley 2017-2022. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+// SPDX-License-Identifier: Apache-2.0
+
+package resolve
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestPinnedSPKIAcceptsMatchingPin(t *testing.T) {
+	cert := selfSignedCertForTest(t)
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	cfg := PinnedSPKI("example.com", sum)
+	if err := cfg.VerifyPeerCertificate([][]byte{cert.Raw}, nil); err != nil {
+		t.Fatalf("expected a matching SPKI pin to verify, got error: %v", err)
+	}
+}
+
+func TestPinnedSPKIRejectsMismatchedPin(t *testing.T) {
+	cert := selfSignedCertForTest(t)
+	var wrongPin [32]byte
+	copy(wrongPin[:], "not-the-right-pin-at-all-123456")
+
+	cfg := PinnedSPKI("example.com", wrongPin)
+	if err := cfg.VerifyPeerCertificate([][]byte{cert.Raw}, nil); err == nil {
+		t.Fatal("expected verification to fail when no pin matches the certificate")
+	}
+}
+
+func selfSignedCertForTest(t *testing.T) *x509.Certificate {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	der := srv.TLS.Certificates[0].Certificate[0]
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse the test server's certificate: %v", err)
+	}
+	return cert
+}
+
+func TestDoHConnWriteReadRoundTrip(t *testing.T) {
+	want := new(dns.Msg)
+	want.SetQuestion("www.example.com.", dns.TypeA)
+	want.Response = true
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		packed, err := want.Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(packed)
+	}))
+	defer srv.Close()
+
+	conn := newDoHConn(srv.URL, nil, time.Second)
+	defer conn.Close()
+
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.com.", dns.TypeA)
+	if err := conn.WriteMsg(req); err != nil {
+		t.Fatalf("unexpected error from WriteMsg: %v", err)
+	}
+
+	got, err := conn.ReadMsg()
+	if err != nil {
+		t.Fatalf("unexpected error from ReadMsg: %v", err)
+	}
+	if len(got.Question) == 0 || got.Question[0].Name != "www.example.com." {
+		t.Fatalf("unexpected answer round-tripped through the DoH connection: %+v", got)
+	}
+}
+
+func TestDoHConnDeliversErrorOnBadResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("not a dns message"))
+	}))
+	defer srv.Close()
+
+	conn := newDoHConn(srv.URL, nil, time.Second)
+	defer conn.Close()
+
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.com.", dns.TypeA)
+	if err := conn.WriteMsg(req); err != nil {
+		t.Fatalf("unexpected error from WriteMsg: %v", err)
+	}
+
+	if _, err := conn.ReadMsg(); err == nil {
+		t.Fatal("expected ReadMsg to report an error for an unparsable response body")
+	}
+}